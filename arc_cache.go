@@ -0,0 +1,352 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+// sizeUnknown marks a cache entry that is known to be present but whose size
+// hasn't been observed yet (e.g. following a bare Has).
+const sizeUnknown = -1
+
+// CachePolicy selects the eviction policy CachedBlockstore builds.
+type CachePolicy int
+
+const (
+	// PolicyARC is the long-standing Adaptive Replacement Cache policy.
+	PolicyARC CachePolicy = iota
+	// PolicyTinyLFU is a scan-resistant W-TinyLFU admission cache, better
+	// suited to workloads with many one-hit-wonder writes (see tinylfu_cache.go).
+	// It does not implement Subscribe, DroppedEvents, or Batch: a
+	// CachedBlockstore built with this policy exposes none of those, even
+	// though the returned value satisfies Blockstore.
+	PolicyTinyLFU
+)
+
+// CacheOpts bundles the cache-wide knobs accepted by CachedBlockstore.
+type CacheOpts struct {
+	HasBloomFilterSize   int
+	HasBloomFilterHashes int
+	HasARCCacheSize      int
+
+	// Policy selects the eviction policy. The zero value, PolicyARC,
+	// preserves the historical behavior.
+	Policy CachePolicy
+
+	// NegativeTTL bounds how long a "block not found" result is trusted
+	// before a repeat lookup re-checks the datastore. The zero value never
+	// expires a negative entry, matching the historical behavior, which is
+	// fine for a blockstore nothing else writes to concurrently but is a
+	// correctness hazard when another writer can make a previously-missing
+	// CID appear.
+	NegativeTTL time.Duration
+}
+
+// DefaultCacheOpts returns a CacheOpts populated with reasonable defaults.
+func DefaultCacheOpts() CacheOpts {
+	return CacheOpts{
+		HasBloomFilterSize:   512 * 8 * 1024,
+		HasBloomFilterHashes: 7,
+		HasARCCacheSize:      64 * 1024,
+		Policy:               PolicyARC,
+		NegativeTTL:          0,
+	}
+}
+
+// cacheEntry is what arccache stores in the ARC for a given CID.
+type cacheEntry struct {
+	present bool
+	size    int // sizeUnknown if present but the size hasn't been learned yet
+
+	// expiresAt is set only on negative (present == false) entries when the
+	// cache has a NegativeTTL configured; the zero Time means "never".
+	expiresAt time.Time
+}
+
+// arccache wraps a Blockstore with an ARC (Adaptive Replacement Cache) that
+// remembers which blocks are present (and, once known, their size) so that
+// repeated Has/Get/GetSize calls for the same CID don't have to round-trip
+// through the underlying datastore.
+type arccache struct {
+	arcLock sync.Mutex
+	arc     *lru.ARCCache
+
+	bs     BlockstoreCtx
+	events *eventDispatcher
+
+	// bloom and negativeTTL back the Has fast path sizing described on
+	// CacheOpts; both are nil/zero (disabled) unless CachedBlockstore wires
+	// them up.
+	bloom       *countingBloom
+	negativeTTL time.Duration
+
+	lockAcquisitions int64 // atomic; see (*arccache).lock
+}
+
+// Subscribe returns a channel of BlockEvents for every Put and DeleteBlock
+// that commits successfully through this cache.
+func (b *arccache) Subscribe(ctx context.Context) (<-chan BlockEvent, error) {
+	return b.events.Subscribe(ctx)
+}
+
+// DroppedEvents reports how many BlockEvents have been dropped because a
+// subscriber fell behind.
+func (b *arccache) DroppedEvents() int64 {
+	return b.events.DroppedEvents()
+}
+
+// CachedBlockstore wraps bs with a cache governed by opts. The eviction
+// policy used is selected by opts.Policy.
+func CachedBlockstore(ctx context.Context, bs Blockstore, opts CacheOpts) (Blockstore, error) {
+	switch opts.Policy {
+	case PolicyTinyLFU:
+		return newTinyLFUCachedBS(bs, opts.HasARCCacheSize)
+	default:
+		arc, err := newARCCachedBS(ctx, bs, opts.HasARCCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		if opts.HasBloomFilterSize > 0 && opts.HasBloomFilterHashes > 0 {
+			arc.bloom = newCountingBloom(opts.HasBloomFilterSize, opts.HasBloomFilterHashes)
+		}
+		arc.negativeTTL = opts.NegativeTTL
+		return arc, nil
+	}
+}
+
+func newARCCachedBS(ctx context.Context, bs Blockstore, lruSize int) (*arccache, error) {
+	if lruSize <= 0 {
+		return nil, fmt.Errorf("arc cache size must be a positive number")
+	}
+	arc, err := lru.NewARC(lruSize)
+	if err != nil {
+		return nil, err
+	}
+	return &arccache{arc: arc, bs: asBlockstoreCtx(bs), events: newEventDispatcher()}, nil
+}
+
+// lock acquires the cache's write lock, counting the acquisition so callers
+// doing bulk work (see Batch in batch.go) can be verified to take it once
+// per operation instead of once per cache mutation.
+func (b *arccache) lock() {
+	atomic.AddInt64(&b.lockAcquisitions, 1)
+	b.arcLock.Lock()
+}
+
+func (b *arccache) unlock() {
+	b.arcLock.Unlock()
+}
+
+func (b *arccache) cacheGet(k string) (cacheEntry, bool) {
+	b.lock()
+	defer b.unlock()
+	e, ok := b.arc.Get(k)
+	if !ok {
+		return cacheEntry{}, false
+	}
+	return e.(cacheEntry), true
+}
+
+func (b *arccache) cacheSet(k string, e cacheEntry) {
+	b.lock()
+	b.arc.Add(k, e)
+	b.unlock()
+}
+
+func (b *arccache) cacheRemove(k string) {
+	b.lock()
+	b.arc.Remove(k)
+	b.unlock()
+}
+
+// negativeEntry builds the cacheEntry recorded for a confirmed-absent key,
+// stamping it with an expiry when NegativeTTL is configured.
+func (b *arccache) negativeEntry() cacheEntry {
+	e := cacheEntry{present: false}
+	if b.negativeTTL > 0 {
+		e.expiresAt = time.Now().Add(b.negativeTTL)
+	}
+	return e
+}
+
+// negativeEntryValid reports whether a cached "not found" result for k can
+// still be trusted: it hasn't expired, and the bloom filter (if any) agrees
+// the key is absent. Either signal alone can be wrong in isolation -- the
+// TTL bounds how stale the entry can be, and the bloom filter catches a
+// presence recorded through a different path (e.g. a concurrent writer)
+// before that TTL elapses.
+func (b *arccache) negativeEntryValid(k string, e cacheEntry) bool {
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		return false
+	}
+	if b.bloom != nil && b.bloom.Has(k) {
+		return false
+	}
+	return true
+}
+
+func (b *arccache) DeleteBlock(c cid.Cid) error {
+	return b.DeleteBlockCtx(context.Background(), c)
+}
+
+func (b *arccache) DeleteBlockCtx(ctx context.Context, c cid.Cid) error {
+	k := c.KeyString()
+	if e, ok := b.cacheGet(k); ok && !e.present && b.negativeEntryValid(k, e) {
+		// Already known absent: keep repeated deletes of a never-written
+		// block idempotent without round-tripping through the datastore.
+		return nil
+	}
+
+	if err := b.bs.DeleteBlockCtx(ctx, c); err != nil {
+		return err
+	}
+	b.cacheRemove(k)
+	if b.bloom != nil {
+		b.bloom.Delete(k)
+	}
+	b.events.emit(BlockEvent{Op: BlockDelete, Cid: c})
+	return nil
+}
+
+func (b *arccache) Has(c cid.Cid) (bool, error) {
+	return b.HasCtx(context.Background(), c)
+}
+
+func (b *arccache) HasCtx(ctx context.Context, c cid.Cid) (bool, error) {
+	k := c.KeyString()
+	if e, ok := b.cacheGet(k); ok && (e.present || b.negativeEntryValid(k, e)) {
+		return e.present, nil
+	}
+
+	has, err := b.bs.HasCtx(ctx, c)
+	if err != nil {
+		// A cancelled or timed-out lookup tells us nothing about whether
+		// the block exists, so it must not poison the cache.
+		return false, err
+	}
+	if has {
+		b.cacheSet(k, cacheEntry{present: true, size: sizeUnknown})
+	} else {
+		b.cacheSet(k, b.negativeEntry())
+	}
+	return has, nil
+}
+
+func (b *arccache) Get(c cid.Cid) (blocks.Block, error) {
+	return b.GetCtx(context.Background(), c)
+}
+
+func (b *arccache) GetCtx(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, ErrNotFound
+	}
+	k := c.KeyString()
+	if e, ok := b.cacheGet(k); ok && !e.present && b.negativeEntryValid(k, e) {
+		return nil, ErrNotFound
+	}
+
+	bl, err := b.bs.GetCtx(ctx, c)
+	if err != nil {
+		if err == ErrNotFound {
+			b.cacheSet(k, b.negativeEntry())
+		}
+		return nil, err
+	}
+	b.cacheSet(k, cacheEntry{present: true, size: len(bl.RawData())})
+	if b.bloom != nil {
+		b.bloom.Add(k)
+	}
+	return bl, nil
+}
+
+func (b *arccache) GetSize(c cid.Cid) (int, error) {
+	return b.GetSizeCtx(context.Background(), c)
+}
+
+func (b *arccache) GetSizeCtx(ctx context.Context, c cid.Cid) (int, error) {
+	k := c.KeyString()
+	if e, ok := b.cacheGet(k); ok {
+		if !e.present {
+			if b.negativeEntryValid(k, e) {
+				return -1, ErrNotFound
+			}
+		} else if e.size != sizeUnknown {
+			return e.size, nil
+		}
+	}
+
+	size, err := b.bs.GetSizeCtx(ctx, c)
+	if err != nil {
+		if err == ErrNotFound {
+			b.cacheSet(k, b.negativeEntry())
+		}
+		return -1, err
+	}
+	b.cacheSet(k, cacheEntry{present: true, size: size})
+	if b.bloom != nil {
+		b.bloom.Add(k)
+	}
+	return size, nil
+}
+
+func (b *arccache) Put(bl blocks.Block) error {
+	return b.PutCtx(context.Background(), bl)
+}
+
+func (b *arccache) PutCtx(ctx context.Context, bl blocks.Block) error {
+	k := bl.Cid().KeyString()
+	if e, ok := b.cacheGet(k); ok && e.present && e.size != sizeUnknown {
+		return nil
+	}
+
+	if err := b.bs.PutCtx(ctx, bl); err != nil {
+		return err
+	}
+	b.cacheSet(k, cacheEntry{present: true, size: len(bl.RawData())})
+	if b.bloom != nil {
+		b.bloom.Add(k)
+	}
+	b.events.emit(BlockEvent{Op: BlockPut, Cid: bl.Cid(), Size: len(bl.RawData())})
+	return nil
+}
+
+func (b *arccache) PutMany(bs []blocks.Block) error {
+	return b.PutManyCtx(context.Background(), bs)
+}
+
+func (b *arccache) PutManyCtx(ctx context.Context, bs []blocks.Block) error {
+	var toPut []blocks.Block
+	for _, bl := range bs {
+		if e, ok := b.cacheGet(bl.Cid().KeyString()); ok && e.present && e.size != sizeUnknown {
+			continue
+		}
+		toPut = append(toPut, bl)
+	}
+	if len(toPut) == 0 {
+		return nil
+	}
+
+	if err := b.bs.PutManyCtx(ctx, toPut); err != nil {
+		return err
+	}
+	for _, bl := range toPut {
+		k := bl.Cid().KeyString()
+		b.cacheSet(k, cacheEntry{present: true, size: len(bl.RawData())})
+		if b.bloom != nil {
+			b.bloom.Add(k)
+		}
+		b.events.emit(BlockEvent{Op: BlockPut, Cid: bl.Cid(), Size: len(bl.RawData())})
+	}
+	return nil
+}
+
+func (b *arccache) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return b.bs.AllKeysChan(ctx)
+}
@@ -0,0 +1,175 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+// Batch accumulates Puts and Deletes and applies them together on Commit,
+// mutating the cache only once the underlying write has succeeded in full.
+// A Batch must not be used concurrently from multiple goroutines, and is no
+// longer usable once Commit or Discard has been called.
+type Batch interface {
+	Put(b blocks.Block) error
+	Delete(c cid.Cid) error
+	Commit(ctx context.Context) error
+	Discard()
+}
+
+// dsBatcher is implemented by a BlockstoreCtx that can hand out a
+// datastore.Batching-backed transaction, letting Batch commit natively
+// instead of falling back to its in-memory journal.
+type dsBatcher interface {
+	newDatastoreBatch(ctx context.Context) (ds.Batch, bool, error)
+}
+
+// batchOp is one queued mutation, recorded in submission order.
+type batchOp struct {
+	op  BlockOp
+	blk blocks.Block // set when op == BlockPut
+	c   cid.Cid      // set when op == BlockDelete
+}
+
+// arccacheBatch is the Batch implementation returned by arccache.Batch. Its
+// ops slice doubles as the write-ahead journal described on commitViaJournal: nothing
+// in the cache or (for non-Batching datastores) the backing store is touched
+// until Commit replays it.
+type arccacheBatch struct {
+	arc  *arccache
+	ops  []batchOp
+	done bool
+}
+
+// Batch returns a handle that can mix Puts and Deletes and apply them as a
+// single unit instead of taking the cache's write lock once per call.
+func (b *arccache) Batch() Batch {
+	return &arccacheBatch{arc: b}
+}
+
+func (bt *arccacheBatch) Put(bl blocks.Block) error {
+	if bt.done {
+		return fmt.Errorf("batch: already committed or discarded")
+	}
+	bt.ops = append(bt.ops, batchOp{op: BlockPut, blk: bl})
+	return nil
+}
+
+func (bt *arccacheBatch) Delete(c cid.Cid) error {
+	if bt.done {
+		return fmt.Errorf("batch: already committed or discarded")
+	}
+	bt.ops = append(bt.ops, batchOp{op: BlockDelete, c: c})
+	return nil
+}
+
+// Discard drops every queued operation. It is a no-op after Commit.
+func (bt *arccacheBatch) Discard() {
+	bt.ops = nil
+	bt.done = true
+}
+
+// Commit writes every queued operation to the datastore, then — only if
+// that succeeds in full — applies all of the corresponding cache mutations
+// under a single write-lock acquisition. On datastore failure the cache is
+// left exactly as it was: no partial positive or negative entries.
+func (bt *arccacheBatch) Commit(ctx context.Context) error {
+	if bt.done {
+		return fmt.Errorf("batch: already committed or discarded")
+	}
+	bt.done = true
+	ops := bt.ops
+	bt.ops = nil
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	if err := bt.commitDatastore(ctx, ops); err != nil {
+		return err
+	}
+
+	bt.arc.lock()
+	for _, o := range ops {
+		switch o.op {
+		case BlockPut:
+			bt.arc.arc.Add(o.blk.Cid().KeyString(), cacheEntry{present: true, size: len(o.blk.RawData())})
+			if bt.arc.bloom != nil {
+				bt.arc.bloom.Add(o.blk.Cid().KeyString())
+			}
+		case BlockDelete:
+			bt.arc.arc.Remove(o.c.KeyString())
+			if bt.arc.bloom != nil {
+				bt.arc.bloom.Delete(o.c.KeyString())
+			}
+		}
+	}
+	bt.arc.unlock()
+
+	for _, o := range ops {
+		switch o.op {
+		case BlockPut:
+			bt.arc.events.emit(BlockEvent{Op: BlockPut, Cid: o.blk.Cid(), Size: len(o.blk.RawData())})
+		case BlockDelete:
+			bt.arc.events.emit(BlockEvent{Op: BlockDelete, Cid: o.c})
+		}
+	}
+	return nil
+}
+
+// commitDatastore writes ops to the backing datastore, preferring a native
+// ds.Batching transaction and falling back to sequential journal replay.
+func (bt *arccacheBatch) commitDatastore(ctx context.Context, ops []batchOp) error {
+	if dsb, ok := bt.arc.bs.(dsBatcher); ok {
+		dsBatch, supported, err := dsb.newDatastoreBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if supported {
+			return bt.commitViaDatastoreBatch(ctx, dsBatch, ops)
+		}
+	}
+	return bt.commitViaJournal(ctx, ops)
+}
+
+func (bt *arccacheBatch) commitViaDatastoreBatch(ctx context.Context, dsBatch ds.Batch, ops []batchOp) error {
+	for _, o := range ops {
+		switch o.op {
+		case BlockPut:
+			if err := dsBatch.Put(ctx, dsKey(o.blk.Cid()), o.blk.RawData()); err != nil {
+				return err
+			}
+		case BlockDelete:
+			if err := dsBatch.Delete(ctx, dsKey(o.c)); err != nil {
+				return err
+			}
+		}
+	}
+	return dsBatch.Commit(ctx)
+}
+
+// commitViaJournal replays ops one at a time against a datastore with no
+// native batching support. ops is itself the write-ahead journal: it is
+// fully built before any datastore call is made. It is held only in memory
+// and does not record which ops have been applied, so a replay that fails
+// partway leaves the earlier ops written. This cannot make a
+// non-transactional datastore atomic, but it guarantees the cache
+// mutations below never run unless every op here returned successfully.
+func (bt *arccacheBatch) commitViaJournal(ctx context.Context, ops []batchOp) error {
+	for _, o := range ops {
+		switch o.op {
+		case BlockPut:
+			if err := bt.arc.bs.PutCtx(ctx, o.blk); err != nil {
+				return err
+			}
+		case BlockDelete:
+			if err := bt.arc.bs.DeleteBlockCtx(ctx, o.c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	syncds "github.com/ipfs/go-datastore/sync"
+)
+
+// erroringDatastore wraps a datastore and fails the Nth mutating call
+// (Put/Delete) it sees, so tests can exercise a batch that fails partway
+// through its journal replay.
+type erroringDatastore struct {
+	ds.Datastore
+	failOn int
+	calls  int
+}
+
+var errBoom = errors.New("erroringDatastore: simulated failure")
+
+func (e *erroringDatastore) Put(ctx context.Context, k ds.Key, v []byte) error {
+	e.calls++
+	if e.calls == e.failOn {
+		return errBoom
+	}
+	return e.Datastore.Put(ctx, k, v)
+}
+
+func (e *erroringDatastore) Delete(ctx context.Context, k ds.Key) error {
+	e.calls++
+	if e.calls == e.failOn {
+		return errBoom
+	}
+	return e.Datastore.Delete(ctx, k)
+}
+
+func (e *erroringDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return e.Datastore.Query(ctx, q)
+}
+
+func createArcOverErroringDatastore(t testing.TB, failOn int) (*arccache, *erroringDatastore) {
+	eds := &erroringDatastore{Datastore: ds.NewMapDatastore(), failOn: failOn}
+	bs := NewBlockstore(syncds.MutexWrap(eds))
+	arc, err := testArcCached(context.TODO(), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return arc, eds
+}
+
+func TestBatchFailedCommitLeavesCacheUntouched(t *testing.T) {
+	// The datastore fails on the second write, so the batch's Put succeeds
+	// but its Delete never reaches the store.
+	arc, _ := createArcOverErroringDatastore(t, 2)
+
+	put := blocks.NewBlock([]byte("batch-put"))
+	del := blocks.NewBlock([]byte("batch-delete"))
+
+	batch := arc.Batch()
+	if err := batch.Put(put); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Delete(del.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := batch.Commit(context.Background()); !errors.Is(err, errBoom) {
+		t.Fatalf("expected Commit to surface the datastore error, got %v", err)
+	}
+
+	if _, ok := arc.cacheGet(put.Cid().KeyString()); ok {
+		t.Fatal("cache must not record a positive entry for a block whose batch commit failed")
+	}
+	if _, ok := arc.cacheGet(del.Cid().KeyString()); ok {
+		t.Fatal("cache must not record a negative entry for a block whose batch commit failed")
+	}
+}
+
+func TestBatchCommitMatchesIndividualCallsWithFewerLocks(t *testing.T) {
+	const n = 10000
+
+	arc, _, _ := createStores(t)
+	blks := make([]blocks.Block, n)
+	for i := 0; i < n; i++ {
+		blks[i] = blocks.NewBlock([]byte{byte(i), byte(i >> 8)})
+	}
+
+	batch := arc.Batch()
+	for _, bl := range blks {
+		if err := batch.Put(bl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	batchLocks := arc.lockAcquisitions
+
+	arcIndividual, _, _ := createStores(t)
+	for _, bl := range blks {
+		if err := arcIndividual.Put(bl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	individualLocks := arcIndividual.lockAcquisitions
+
+	if batchLocks >= individualLocks {
+		t.Fatalf("expected batch commit to take the cache lock far fewer times: batch=%d individual=%d", batchLocks, individualLocks)
+	}
+
+	for _, bl := range blks {
+		e1, ok1 := arc.cacheGet(bl.Cid().KeyString())
+		e2, ok2 := arcIndividual.cacheGet(bl.Cid().KeyString())
+		if ok1 != ok2 || e1 != e2 {
+			t.Fatalf("batch and individual-call cache state diverged for %s: %+v vs %+v", bl.Cid(), e1, e2)
+		}
+	}
+}
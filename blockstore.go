@@ -0,0 +1,335 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// ErrNotFound is returned by a Blockstore when a block is not present.
+var ErrNotFound = errors.New("blockstore: block not found")
+
+// ErrHashMismatch is returned when the CID of a fetched block does not match
+// the hash of the bytes retrieved from the datastore.
+var ErrHashMismatch = errors.New("blockstore: block in datastore has different hash than requested")
+
+// errSkipPut is an internal sentinel PutCtx uses to short-circuit out of its
+// withCtxCancel closure when the block is already present; it never escapes
+// PutCtx.
+var errSkipPut = errors.New("blockstore: block already present")
+
+// blockPrefix namespaces every block key written to the underlying
+// datastore, keeping them out of the way of any other data the caller may
+// store alongside them.
+const blockPrefix = "/blocks"
+
+// Blockstore wraps a datastore and adds a thin layer for storing and
+// retrieving IPFS blocks by their CID.
+type Blockstore interface {
+	DeleteBlock(c cid.Cid) error
+	Has(c cid.Cid) (bool, error)
+	Get(c cid.Cid) (blocks.Block, error)
+	GetSize(c cid.Cid) (int, error)
+	Put(b blocks.Block) error
+	PutMany(bs []blocks.Block) error
+	AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
+}
+
+// BlockstoreCtx is implemented by every Blockstore in this package. It adds
+// a context-aware variant of each method so that a caller can bound an
+// in-flight datastore call with cancellation or a deadline instead of
+// blocking until it completes. The plain Blockstore methods remain available
+// and are equivalent to calling the Ctx variant with context.Background().
+type BlockstoreCtx interface {
+	Blockstore
+
+	DeleteBlockCtx(ctx context.Context, c cid.Cid) error
+	HasCtx(ctx context.Context, c cid.Cid) (bool, error)
+	GetCtx(ctx context.Context, c cid.Cid) (blocks.Block, error)
+	GetSizeCtx(ctx context.Context, c cid.Cid) (int, error)
+	PutCtx(ctx context.Context, b blocks.Block) error
+	PutManyCtx(ctx context.Context, bs []blocks.Block) error
+}
+
+type blockstore struct {
+	datastore ds.Datastore
+	events    *eventDispatcher
+}
+
+// NewBlockstore returns a default Blockstore implementation backed by the
+// given datastore.
+func NewBlockstore(d ds.Datastore) Blockstore {
+	return &blockstore{datastore: d, events: newEventDispatcher()}
+}
+
+// Subscribe returns a channel of BlockEvents for every Put and DeleteBlock
+// that commits successfully to the underlying datastore.
+func (bs *blockstore) Subscribe(ctx context.Context) (<-chan BlockEvent, error) {
+	return bs.events.Subscribe(ctx)
+}
+
+// DroppedEvents reports how many BlockEvents have been dropped because a
+// subscriber fell behind.
+func (bs *blockstore) DroppedEvents() int64 {
+	return bs.events.DroppedEvents()
+}
+
+func dsKey(c cid.Cid) ds.Key {
+	return ds.NewKey(blockPrefix).ChildString(c.String())
+}
+
+// withCtxCancel runs fn on its own goroutine and returns as soon as either fn
+// finishes or ctx is done, whichever comes first. None of this package's
+// datastores (ds.MapDatastore, sync.MutexWrap, delayed.New, ...) check ctx
+// themselves, so without this a cancelled or expired context would have zero
+// effect on an in-flight call. If ctx wins the race, fn is left running in
+// the background and its result is discarded.
+func withCtxCancel(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bs *blockstore) DeleteBlock(c cid.Cid) error {
+	return bs.DeleteBlockCtx(context.Background(), c)
+}
+
+func (bs *blockstore) DeleteBlockCtx(ctx context.Context, c cid.Cid) error {
+	if err := withCtxCancel(ctx, func() error {
+		return bs.datastore.Delete(ctx, dsKey(c))
+	}); err != nil {
+		return err
+	}
+	bs.events.emit(BlockEvent{Op: BlockDelete, Cid: c})
+	return nil
+}
+
+func (bs *blockstore) Has(c cid.Cid) (bool, error) {
+	return bs.HasCtx(context.Background(), c)
+}
+
+func (bs *blockstore) HasCtx(ctx context.Context, c cid.Cid) (bool, error) {
+	type result struct {
+		has bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		has, err := bs.datastore.Has(ctx, dsKey(c))
+		done <- result{has, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.has, r.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (bs *blockstore) Get(c cid.Cid) (blocks.Block, error) {
+	return bs.GetCtx(context.Background(), c)
+}
+
+func (bs *blockstore) GetCtx(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, ErrNotFound
+	}
+	type result struct {
+		bdata []byte
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		bdata, err := bs.datastore.Get(ctx, dsKey(c))
+		done <- result{bdata, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			if r.err == ds.ErrNotFound {
+				return nil, ErrNotFound
+			}
+			return nil, r.err
+		}
+		return blocks.NewBlockWithCid(r.bdata, c)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (bs *blockstore) GetSize(c cid.Cid) (int, error) {
+	return bs.GetSizeCtx(context.Background(), c)
+}
+
+func (bs *blockstore) GetSizeCtx(ctx context.Context, c cid.Cid) (int, error) {
+	type result struct {
+		size int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		size, err := bs.datastore.GetSize(ctx, dsKey(c))
+		done <- result{size, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err == ds.ErrNotFound {
+			return -1, ErrNotFound
+		}
+		return r.size, r.err
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+func (bs *blockstore) Put(b blocks.Block) error {
+	return bs.PutCtx(context.Background(), b)
+}
+
+func (bs *blockstore) PutCtx(ctx context.Context, b blocks.Block) error {
+	k := dsKey(b.Cid())
+
+	err := withCtxCancel(ctx, func() error {
+		// Don't re-write blocks we already have, to minimize io.
+		exists, err := bs.datastore.Has(ctx, k)
+		if err == nil && exists {
+			return errSkipPut
+		}
+		return bs.datastore.Put(ctx, k, b.RawData())
+	})
+	if err == errSkipPut {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bs.events.emit(BlockEvent{Op: BlockPut, Cid: b.Cid(), Size: len(b.RawData())})
+	return nil
+}
+
+func (bs *blockstore) PutMany(blks []blocks.Block) error {
+	return bs.PutManyCtx(context.Background(), blks)
+}
+
+func (bs *blockstore) PutManyCtx(ctx context.Context, blks []blocks.Block) error {
+	for _, b := range blks {
+		if err := bs.PutCtx(ctx, b); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// newDatastoreBatch returns a ds.Batch for the underlying datastore when it
+// implements ds.Batching, so that Batch (see batch.go) can stage a multi-op
+// commit natively instead of falling back to its in-memory journal.
+func (bs *blockstore) newDatastoreBatch(ctx context.Context) (ds.Batch, bool, error) {
+	batching, ok := bs.datastore.(ds.Batching)
+	if !ok {
+		return nil, false, nil
+	}
+	dsb, err := batching.Batch(ctx)
+	if err == ds.ErrBatchUnsupported {
+		// A wrapper (e.g. sync.MutexWrap) can unconditionally satisfy
+		// ds.Batching while the datastore it wraps does not; treat that the
+		// same as the type assertion above failing outright.
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return dsb, true, nil
+}
+
+func (bs *blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	q := dsq.Query{Prefix: blockPrefix, KeysOnly: true}
+	res, err := bs.datastore.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan cid.Cid)
+	go func() {
+		defer close(output)
+		defer res.Close()
+		for {
+			e, ok := res.NextSync()
+			if !ok {
+				return
+			}
+			if e.Error != nil {
+				return
+			}
+
+			c, err := cid.Decode(ds.NewKey(e.Key).Name())
+			if err != nil {
+				continue
+			}
+
+			select {
+			case output <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+// blockstoreCtxShim adapts a plain Blockstore into a BlockstoreCtx for
+// implementations outside this package that haven't been upgraded yet. It
+// cannot actually abort an in-flight call on ctx cancellation since the
+// wrapped Blockstore has no way to hear about it, but it keeps such
+// implementations usable with code that expects a BlockstoreCtx.
+type blockstoreCtxShim struct {
+	Blockstore
+}
+
+func (s blockstoreCtxShim) DeleteBlockCtx(ctx context.Context, c cid.Cid) error {
+	return s.DeleteBlock(c)
+}
+
+func (s blockstoreCtxShim) HasCtx(ctx context.Context, c cid.Cid) (bool, error) {
+	return s.Has(c)
+}
+
+func (s blockstoreCtxShim) GetCtx(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return s.Get(c)
+}
+
+func (s blockstoreCtxShim) GetSizeCtx(ctx context.Context, c cid.Cid) (int, error) {
+	return s.GetSize(c)
+}
+
+func (s blockstoreCtxShim) PutCtx(ctx context.Context, b blocks.Block) error {
+	return s.Put(b)
+}
+
+func (s blockstoreCtxShim) PutManyCtx(ctx context.Context, bs []blocks.Block) error {
+	return s.PutMany(bs)
+}
+
+// asBlockstoreCtx returns bs as a BlockstoreCtx, wrapping it in a shim if it
+// doesn't already implement one.
+func asBlockstoreCtx(bs Blockstore) BlockstoreCtx {
+	if bc, ok := bs.(BlockstoreCtx); ok {
+		return bc
+	}
+	return blockstoreCtxShim{bs}
+}
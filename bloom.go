@@ -0,0 +1,67 @@
+package blockstore
+
+import "hash/fnv"
+
+// countingBloom is a bloom filter backed by 4-bit saturating counters rather
+// than plain bits. A classic bit-set bloom filter can only ever set bits, so
+// it has no way to reflect a Delete; this one decrements a key's counters
+// instead, so a deleted key can become "probably absent" again rather than
+// leaving a false positive behind forever.
+type countingBloom struct {
+	hashes   int
+	size     int // number of counters; each byte packs two
+	counters []byte
+}
+
+func newCountingBloom(size, hashes int) *countingBloom {
+	if size < 16 {
+		size = 16
+	}
+	if size%2 != 0 {
+		size++
+	}
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &countingBloom{hashes: hashes, size: size, counters: make([]byte, size/2)}
+}
+
+func (bf *countingBloom) indices(key string) []int {
+	out := make([]int, bf.hashes)
+	for i := 0; i < bf.hashes; i++ {
+		h := fnv.New64a()
+		h.Write([]byte{byte(i)})
+		h.Write([]byte(key))
+		out[i] = int(h.Sum64() % uint64(bf.size))
+	}
+	return out
+}
+
+// Add records key's presence.
+func (bf *countingBloom) Add(key string) {
+	for _, idx := range bf.indices(key) {
+		if c := getNibble(bf.counters, idx); c < 15 {
+			setNibble(bf.counters, idx, c+1)
+		}
+	}
+}
+
+// Delete undoes one Add for key.
+func (bf *countingBloom) Delete(key string) {
+	for _, idx := range bf.indices(key) {
+		if c := getNibble(bf.counters, idx); c > 0 {
+			setNibble(bf.counters, idx, c-1)
+		}
+	}
+}
+
+// Has reports whether key is possibly present (false positives are
+// possible; false negatives are not, barring a counter saturating at 15).
+func (bf *countingBloom) Has(key string) bool {
+	for _, idx := range bf.indices(key) {
+		if getNibble(bf.counters, idx) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package blockstore
+
+import (
+	"context"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// callbackDatastore wraps a datastore and invokes f before every Put, Get,
+// Has, GetSize, Delete and Query (Sync and Close do not invoke it), letting
+// tests assert that a given call never (or always) reaches the underlying
+// store.
+type callbackDatastore struct {
+	sync.Mutex
+	f  func()
+	ds ds.Datastore
+}
+
+// SetFunc swaps the callback invoked on each of those datastore operations.
+func (c *callbackDatastore) SetFunc(f func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.f = f
+}
+
+func (c *callbackDatastore) callback() {
+	c.Lock()
+	f := c.f
+	c.Unlock()
+	f()
+}
+
+func (c *callbackDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	c.callback()
+	return c.ds.Put(ctx, key, value)
+}
+
+func (c *callbackDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	c.callback()
+	return c.ds.Get(ctx, key)
+}
+
+func (c *callbackDatastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	c.callback()
+	return c.ds.Has(ctx, key)
+}
+
+func (c *callbackDatastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	c.callback()
+	return c.ds.GetSize(ctx, key)
+}
+
+func (c *callbackDatastore) Delete(ctx context.Context, key ds.Key) error {
+	c.callback()
+	return c.ds.Delete(ctx, key)
+}
+
+func (c *callbackDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	c.callback()
+	return c.ds.Query(ctx, q)
+}
+
+func (c *callbackDatastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return c.ds.Sync(ctx, prefix)
+}
+
+func (c *callbackDatastore) Close() error {
+	return c.ds.Close()
+}
@@ -0,0 +1,79 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	delay "github.com/ipfs/go-ipfs-delay"
+)
+
+// TestCtxCancelDoesNotPoisonCache runs the read-side Ctx methods (HasCtx,
+// GetCtx, GetSizeCtx) against an already-cancelled context and asserts that
+// the aborted call leaves no trace in the ARC: no cache entry exists for the
+// key afterwards, so a later call cannot trust a result of the aborted request.
+func TestCtxCancelDoesNotPoisonCache(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(ctx context.Context, arc *arccache, bl blocks.Block) error
+	}{
+		{
+			name: "Has",
+			run: func(ctx context.Context, arc *arccache, bl blocks.Block) error {
+				_, err := arc.HasCtx(ctx, bl.Cid())
+				return err
+			},
+		},
+		{
+			name: "Get",
+			run: func(ctx context.Context, arc *arccache, bl blocks.Block) error {
+				_, err := arc.GetCtx(ctx, bl.Cid())
+				return err
+			},
+		},
+		{
+			name: "GetSize",
+			run: func(ctx context.Context, arc *arccache, bl blocks.Block) error {
+				_, err := arc.GetSizeCtx(ctx, bl.Cid())
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc, _, _ := createStoresWithDelay(t, delay.Fixed(50*time.Millisecond))
+			bl := blocks.NewBlock([]byte("ctx-cancel-" + tc.name))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			if err := tc.run(ctx, arc, bl); err == nil {
+				t.Fatal("expected the cancelled call to return an error")
+			}
+
+			if _, ok := arc.cacheGet(bl.Cid().KeyString()); ok {
+				t.Fatal("cancelled call must not have written a cache entry")
+			}
+		})
+	}
+}
+
+// TestCtxDeadlineDoesNotPoisonCache is the same check for a deadline that
+// expires mid-request rather than an already-cancelled context.
+func TestCtxDeadlineDoesNotPoisonCache(t *testing.T) {
+	arc, _, _ := createStoresWithDelay(t, delay.Fixed(50*time.Millisecond))
+	bl := blocks.NewBlock([]byte("ctx-deadline"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, err := arc.GetCtx(ctx, bl.Cid()); err == nil {
+		t.Fatal("expected the expired-deadline call to return an error")
+	}
+
+	if _, ok := arc.cacheGet(bl.Cid().KeyString()); ok {
+		t.Fatal("call that hit its deadline must not have written a cache entry")
+	}
+}
@@ -0,0 +1,96 @@
+package blockstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// BlockOp identifies the kind of mutation a BlockEvent reports.
+type BlockOp int
+
+const (
+	// BlockPut reports that a block was written to the datastore.
+	BlockPut BlockOp = iota
+	// BlockDelete reports that a block was removed from the datastore.
+	BlockDelete
+)
+
+func (op BlockOp) String() string {
+	switch op {
+	case BlockPut:
+		return "Put"
+	case BlockDelete:
+		return "Delete"
+	default:
+		return "Unknown"
+	}
+}
+
+// BlockEvent reports a single Put or Delete that has already been committed
+// to the underlying datastore. Size is only meaningful for BlockPut.
+type BlockEvent struct {
+	Op   BlockOp
+	Cid  cid.Cid
+	Size int
+}
+
+// subscriberBufferSize bounds how many events a subscriber can lag behind
+// before emit starts dropping events destined for it.
+const subscriberBufferSize = 64
+
+// eventDispatcher fans a stream of BlockEvents out to any number of
+// subscribers. Each subscriber has its own bounded buffer so one slow
+// consumer can only drop its own events (counted in dropped) rather than
+// stall Put/Delete for everyone else.
+type eventDispatcher struct {
+	mu          sync.Mutex
+	subscribers map[chan BlockEvent]struct{}
+	dropped     int64
+}
+
+func newEventDispatcher() *eventDispatcher {
+	return &eventDispatcher{subscribers: make(map[chan BlockEvent]struct{})}
+}
+
+// Subscribe returns a channel of BlockEvents that stays open until ctx is
+// done, at which point it is unregistered and closed.
+func (d *eventDispatcher) Subscribe(ctx context.Context) (<-chan BlockEvent, error) {
+	ch := make(chan BlockEvent, subscriberBufferSize)
+
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// emit delivers ev to every current subscriber, dropping it (and counting
+// the drop) for any subscriber whose buffer is full.
+func (d *eventDispatcher) emit(ev BlockEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddInt64(&d.dropped, 1)
+		}
+	}
+}
+
+// DroppedEvents reports how many events have been dropped so far because a
+// subscriber's buffer was full.
+func (d *eventDispatcher) DroppedEvents() int64 {
+	return atomic.LoadInt64(&d.dropped)
+}
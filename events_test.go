@@ -0,0 +1,105 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func recvEvent(t *testing.T, ch <-chan BlockEvent) BlockEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("event channel closed before an event arrived")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a BlockEvent")
+	}
+	return BlockEvent{}
+}
+
+func TestArccacheSubscribeObservesDeleteOnce(t *testing.T) {
+	arc, _, _ := createStores(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := arc.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Put(exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	if ev := recvEvent(t, ch); ev.Op != BlockPut || ev.Cid != exampleBlock.Cid() {
+		t.Fatalf("unexpected Put event: %+v", ev)
+	}
+
+	if err := arc.DeleteBlock(exampleBlock.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if ev := recvEvent(t, ch); ev.Op != BlockDelete || ev.Cid != exampleBlock.Cid() {
+		t.Fatalf("unexpected Delete event: %+v", ev)
+	}
+
+	select {
+	case ev := <-ch:
+		t.Fatalf("expected exactly one Delete event, got a second: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestArccacheSubscribePutManyOrdered(t *testing.T) {
+	arc, _, _ := createStores(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := arc.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blks := []blocks.Block{
+		blocks.NewBlock([]byte("a")),
+		blocks.NewBlock([]byte("b")),
+		blocks.NewBlock([]byte("c")),
+	}
+	if err := arc.PutMany(blks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range blks {
+		ev := recvEvent(t, ch)
+		if ev.Op != BlockPut || ev.Cid != want.Cid() {
+			t.Fatalf("expected Put event for %s, got %+v", want.Cid(), ev)
+		}
+	}
+}
+
+func TestArccacheDroppedEventsCounted(t *testing.T) {
+	arc, _, _ := createStores(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := arc.Subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flood the subscriber past its buffer without ever draining it.
+	for i := 0; i < subscriberBufferSize+10; i++ {
+		if err := arc.Put(blocks.NewBlock([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if arc.DroppedEvents() == 0 {
+		t.Fatal("expected some events to have been dropped for the stalled subscriber")
+	}
+}
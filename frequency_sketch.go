@@ -0,0 +1,112 @@
+package blockstore
+
+import "hash/fnv"
+
+// countMinSketch is a Count-Min Sketch of 4-bit saturating counters used by
+// tinyLFUCache to estimate how often a key has been accessed without having
+// to keep an exact per-key count. Counters are periodically halved ("aged")
+// so that the sketch tracks recent activity rather than all-time totals.
+type countMinSketch struct {
+	depth int // number of hash functions
+	width int // counters per row; must be even, each byte packs two
+
+	// rows[d] holds width/2 bytes of 4-bit counters for hash function d.
+	rows [][]byte
+
+	increments    int
+	agingInterval int
+}
+
+const sketchDepth = 4
+
+// newCountMinSketch sizes the sketch to roughly 10x capacity counters per
+// row, aging (halving every counter) once that many increments accumulate.
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := capacity * 10
+	if width < 16 {
+		width = 16
+	}
+	if width%2 != 0 {
+		width++
+	}
+
+	rows := make([][]byte, sketchDepth)
+	for i := range rows {
+		rows[i] = make([]byte, width/2)
+	}
+
+	return &countMinSketch{
+		depth:         sketchDepth,
+		width:         width,
+		rows:          rows,
+		agingInterval: width,
+	}
+}
+
+func (s *countMinSketch) indices(key string) [sketchDepth]int {
+	var out [sketchDepth]int
+	for d := 0; d < s.depth; d++ {
+		h := fnv.New64a()
+		h.Write([]byte{byte(d)})
+		h.Write([]byte(key))
+		out[d] = int(h.Sum64() % uint64(s.width))
+	}
+	return out
+}
+
+func getNibble(row []byte, idx int) byte {
+	b := row[idx/2]
+	if idx%2 == 0 {
+		return b & 0x0F
+	}
+	return b >> 4
+}
+
+func setNibble(row []byte, idx int, v byte) {
+	if idx%2 == 0 {
+		row[idx/2] = (row[idx/2] & 0xF0) | (v & 0x0F)
+	} else {
+		row[idx/2] = (row[idx/2] & 0x0F) | (v << 4)
+	}
+}
+
+// Increment records one access to key, aging the whole sketch once enough
+// increments have accumulated.
+func (s *countMinSketch) Increment(key string) {
+	idx := s.indices(key)
+	for d := 0; d < s.depth; d++ {
+		c := getNibble(s.rows[d], idx[d])
+		if c < 15 {
+			setNibble(s.rows[d], idx[d], c+1)
+		}
+	}
+
+	s.increments++
+	if s.increments >= s.agingInterval {
+		s.age()
+	}
+}
+
+// Estimate returns the sketch's best guess at key's access frequency: the
+// minimum across all of its hashed counters.
+func (s *countMinSketch) Estimate(key string) byte {
+	idx := s.indices(key)
+	min := byte(15)
+	for d := 0; d < s.depth; d++ {
+		c := getNibble(s.rows[d], idx[d])
+		if c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// age halves every counter, letting the sketch forget stale activity.
+func (s *countMinSketch) age() {
+	for _, row := range s.rows {
+		for i := 0; i < len(row)*2; i++ {
+			setNibble(row, i, getNibble(row, i)>>1)
+		}
+	}
+	s.increments = 0
+}
@@ -0,0 +1,94 @@
+package blockstore
+
+import "container/list"
+
+// lfuEntry is the value stored in each lruSegment of a tinyLFUCache.
+type lfuEntry struct {
+	key     string
+	present bool
+	size    int // sizeUnknown if present but the size hasn't been learned yet
+}
+
+// lruSegment is a fixed-capacity, map-backed LRU list. It is the building
+// block tinyLFUCache uses for its window and its two SLRU segments
+// (probationary and protected).
+type lruSegment struct {
+	cap   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLRUSegment(capacity int) *lruSegment {
+	return &lruSegment{
+		cap:   capacity,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, capacity),
+	}
+}
+
+func (s *lruSegment) len() int { return s.ll.Len() }
+
+// peek returns the entry for key without affecting its recency.
+func (s *lruSegment) peek(key string) (*lfuEntry, bool) {
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*lfuEntry), true
+}
+
+// get returns the entry for key and marks it most-recently-used.
+func (s *lruSegment) get(key string) (*lfuEntry, bool) {
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*lfuEntry), true
+}
+
+// add inserts e as the most-recently-used entry, or refreshes it in place if
+// its key is already present. If the segment was at capacity, the evicted
+// least-recently-used entry is returned with full=true.
+func (s *lruSegment) add(e *lfuEntry) (evicted *lfuEntry, full bool) {
+	if el, ok := s.items[e.key]; ok {
+		el.Value = e
+		s.ll.MoveToFront(el)
+		return nil, false
+	}
+
+	el := s.ll.PushFront(e)
+	s.items[e.key] = el
+	if s.ll.Len() <= s.cap {
+		return nil, false
+	}
+	return s.removeOldest(), true
+}
+
+func (s *lruSegment) remove(key string) {
+	if el, ok := s.items[key]; ok {
+		s.ll.Remove(el)
+		delete(s.items, key)
+	}
+}
+
+// peekOldest returns the least-recently-used entry without removing it.
+func (s *lruSegment) peekOldest() *lfuEntry {
+	back := s.ll.Back()
+	if back == nil {
+		return nil
+	}
+	return back.Value.(*lfuEntry)
+}
+
+// removeOldest evicts and returns the least-recently-used entry.
+func (s *lruSegment) removeOldest() *lfuEntry {
+	back := s.ll.Back()
+	if back == nil {
+		return nil
+	}
+	s.ll.Remove(back)
+	e := back.Value.(*lfuEntry)
+	delete(s.items, e.key)
+	return e
+}
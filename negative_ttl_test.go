@@ -0,0 +1,95 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+)
+
+func createStoresWithOpts(t testing.TB, opts CacheOpts) (*arccache, Blockstore, *callbackDatastore) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	bbs, err := CachedBlockstore(context.TODO(), bs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bbs.(*arccache), bs, cd
+}
+
+// TestNegativeTTLExpiryRevalidates is the regression case from the request:
+// a block is queried (miss, cached negative), a second writer puts it
+// directly to the underlying store, and a query issued after NegativeTTL
+// elapses must hit the datastore and observe the write instead of trusting
+// the stale negative cache entry.
+func TestNegativeTTLExpiryRevalidates(t *testing.T) {
+	opts := DefaultCacheOpts()
+	opts.HasBloomFilterSize = 0
+	opts.HasBloomFilterHashes = 0
+	opts.NegativeTTL = 20 * time.Millisecond
+
+	arc, bs, _ := createStoresWithOpts(t, opts)
+
+	if has, err := arc.Has(exampleBlock.Cid()); has || err != nil {
+		t.Fatal("expected a miss for a block nobody has written yet")
+	}
+
+	// A second writer puts the block directly to the shared underlying
+	// blockstore, bypassing this cache entirely.
+	if err := bs.Put(exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := arc.Has(exampleBlock.Cid()); has {
+		t.Fatal("expected the still-fresh negative entry to still be trusted")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	has, err := arc.Has(exampleBlock.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Fatal("expected the expired negative entry to be revalidated against the datastore")
+	}
+
+	bl, err := arc.Get(exampleBlock.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bl.RawData()) != string(exampleBlock.RawData()) {
+		t.Fatal("expected to read back the block written by the second writer")
+	}
+}
+
+func TestNegativeTTLZeroNeverExpires(t *testing.T) {
+	arc, bs, cd := createStoresWithOpts(t, DefaultCacheOpts())
+
+	if has, err := arc.Has(exampleBlock.Cid()); has || err != nil {
+		t.Fatal("expected a miss")
+	}
+
+	if err := bs.Put(exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	trap("has hit datastore", cd, t)
+	if has, _ := arc.Has(exampleBlock.Cid()); has {
+		t.Fatal("expected the negative entry to still be trusted with NegativeTTL disabled")
+	}
+}
+
+func TestCountingBloomForgetsDeletedKey(t *testing.T) {
+	bf := newCountingBloom(1024, 4)
+	bf.Add("a")
+	if !bf.Has("a") {
+		t.Fatal("expected bloom to report a key it was told about")
+	}
+	bf.Delete("a")
+	if bf.Has("a") {
+		t.Fatal("expected Delete to undo the earlier Add")
+	}
+}
@@ -0,0 +1,328 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+// windowRatio and protectedRatio follow the W-TinyLFU design: a small LRU
+// window admits every new key, and the remaining capacity is split into a
+// probationary and a protected SLRU segment.
+const (
+	windowRatio    = 0.01
+	protectedRatio = 0.8
+)
+
+// tinyLFUCache is a scan-resistant alternative to arccache. New keys always
+// enter a small LRU window; when the window overflows, the evicted key is
+// only admitted into the main SLRU cache if a Count-Min frequency sketch
+// says it's hotter than whatever it would displace. This keeps a flood of
+// one-hit-wonder writes from trashing the cache the way a plain LRU/ARC can.
+type tinyLFUCache struct {
+	mu sync.Mutex
+
+	window    *lruSegment
+	probation *lruSegment
+	protected *lruSegment
+
+	sketch *countMinSketch
+
+	bs BlockstoreCtx
+}
+
+func newTinyLFUCachedBS(bs Blockstore, capacity int) (*tinyLFUCache, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("tinyLFU cache size must be a positive number")
+	}
+
+	windowCap := int(float64(capacity) * windowRatio)
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	if mainCap < 1 {
+		mainCap = 1
+	}
+	protectedCap := int(float64(mainCap) * protectedRatio)
+	probationCap := mainCap - protectedCap
+	if probationCap < 1 {
+		probationCap = 1
+	}
+
+	return &tinyLFUCache{
+		window:    newLRUSegment(windowCap),
+		probation: newLRUSegment(probationCap),
+		protected: newLRUSegment(protectedCap),
+		sketch:    newCountMinSketch(capacity),
+		bs:        asBlockstoreCtx(bs),
+	}, nil
+}
+
+// find looks up key in whichever segment currently holds it, without
+// affecting recency.
+func (c *tinyLFUCache) find(key string) (*lruSegment, *lfuEntry, bool) {
+	if e, ok := c.window.peek(key); ok {
+		return c.window, e, true
+	}
+	if e, ok := c.probation.peek(key); ok {
+		return c.probation, e, true
+	}
+	if e, ok := c.protected.peek(key); ok {
+		return c.protected, e, true
+	}
+	return nil, nil, false
+}
+
+// onHit records an access to an entry already in the cache, promoting a
+// probationary entry to protected.
+func (c *tinyLFUCache) onHit(seg *lruSegment, e *lfuEntry) {
+	c.sketch.Increment(e.key)
+
+	if seg != c.probation {
+		seg.get(e.key)
+		return
+	}
+
+	c.probation.remove(e.key)
+	if demoted, full := c.protected.add(e); full {
+		c.probation.add(demoted)
+	}
+}
+
+// onMiss admits a freshly-loaded entry into the window, running the
+// frequency-based admission race against the probation segment's LRU victim
+// if the window overflows.
+func (c *tinyLFUCache) onMiss(e *lfuEntry) {
+	c.sketch.Increment(e.key)
+
+	evicted, full := c.window.add(e)
+	if !full {
+		return
+	}
+	c.admit(evicted)
+}
+
+// admit decides whether a window evictee displaces the probation segment's
+// LRU victim, keeping whichever the frequency sketch estimates is hotter.
+func (c *tinyLFUCache) admit(candidate *lfuEntry) {
+	if c.probation.len() < c.probation.cap {
+		c.probation.add(candidate)
+		return
+	}
+
+	victim := c.probation.peekOldest()
+	if victim == nil {
+		c.probation.add(candidate)
+		return
+	}
+
+	if c.sketch.Estimate(candidate.key) > c.sketch.Estimate(victim.key) {
+		c.probation.removeOldest()
+		c.probation.add(candidate)
+	}
+	// Otherwise the candidate is no hotter than the incumbent and is dropped.
+}
+
+// insertOrUpdate refreshes an entry already tracked by the cache in place,
+// or runs it through the window admission path if it's new.
+func (c *tinyLFUCache) insertOrUpdate(e *lfuEntry) {
+	if seg, existing, ok := c.find(e.key); ok {
+		existing.present = e.present
+		existing.size = e.size
+		c.onHit(seg, existing)
+		return
+	}
+	c.onMiss(e)
+}
+
+func (c *tinyLFUCache) DeleteBlock(cc cid.Cid) error {
+	return c.DeleteBlockCtx(context.Background(), cc)
+}
+
+func (c *tinyLFUCache) DeleteBlockCtx(ctx context.Context, cc cid.Cid) error {
+	k := cc.KeyString()
+
+	c.mu.Lock()
+	if _, e, ok := c.find(k); ok && !e.present {
+		// Already known absent: keep repeated deletes of a never-written
+		// block idempotent without round-tripping through the datastore.
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	if err := c.bs.DeleteBlockCtx(ctx, cc); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.window.remove(k)
+	c.probation.remove(k)
+	c.protected.remove(k)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *tinyLFUCache) Has(cc cid.Cid) (bool, error) {
+	return c.HasCtx(context.Background(), cc)
+}
+
+func (c *tinyLFUCache) HasCtx(ctx context.Context, cc cid.Cid) (bool, error) {
+	k := cc.KeyString()
+
+	c.mu.Lock()
+	if seg, e, ok := c.find(k); ok {
+		c.onHit(seg, e)
+		present := e.present
+		c.mu.Unlock()
+		return present, nil
+	}
+	c.mu.Unlock()
+
+	has, err := c.bs.HasCtx(ctx, cc)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.onMiss(&lfuEntry{key: k, present: has, size: sizeUnknown})
+	c.mu.Unlock()
+	return has, nil
+}
+
+func (c *tinyLFUCache) Get(cc cid.Cid) (blocks.Block, error) {
+	return c.GetCtx(context.Background(), cc)
+}
+
+func (c *tinyLFUCache) GetCtx(ctx context.Context, cc cid.Cid) (blocks.Block, error) {
+	if !cc.Defined() {
+		return nil, ErrNotFound
+	}
+	k := cc.KeyString()
+
+	c.mu.Lock()
+	if seg, e, ok := c.find(k); ok && !e.present {
+		c.onHit(seg, e)
+		c.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	c.mu.Unlock()
+
+	bl, err := c.bs.GetCtx(ctx, cc)
+	if err != nil {
+		if err == ErrNotFound {
+			c.mu.Lock()
+			c.insertOrUpdate(&lfuEntry{key: k, present: false})
+			c.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.insertOrUpdate(&lfuEntry{key: k, present: true, size: len(bl.RawData())})
+	c.mu.Unlock()
+	return bl, nil
+}
+
+func (c *tinyLFUCache) GetSize(cc cid.Cid) (int, error) {
+	return c.GetSizeCtx(context.Background(), cc)
+}
+
+func (c *tinyLFUCache) GetSizeCtx(ctx context.Context, cc cid.Cid) (int, error) {
+	k := cc.KeyString()
+
+	c.mu.Lock()
+	if seg, e, ok := c.find(k); ok {
+		if !e.present {
+			c.onHit(seg, e)
+			c.mu.Unlock()
+			return -1, ErrNotFound
+		}
+		if e.size != sizeUnknown {
+			c.onHit(seg, e)
+			c.mu.Unlock()
+			return e.size, nil
+		}
+	}
+	c.mu.Unlock()
+
+	size, err := c.bs.GetSizeCtx(ctx, cc)
+	if err != nil {
+		if err == ErrNotFound {
+			c.mu.Lock()
+			c.insertOrUpdate(&lfuEntry{key: k, present: false})
+			c.mu.Unlock()
+		}
+		return -1, err
+	}
+
+	c.mu.Lock()
+	c.insertOrUpdate(&lfuEntry{key: k, present: true, size: size})
+	c.mu.Unlock()
+	return size, nil
+}
+
+func (c *tinyLFUCache) Put(bl blocks.Block) error {
+	return c.PutCtx(context.Background(), bl)
+}
+
+func (c *tinyLFUCache) PutCtx(ctx context.Context, bl blocks.Block) error {
+	k := bl.Cid().KeyString()
+
+	c.mu.Lock()
+	if seg, e, ok := c.find(k); ok && e.present && e.size != sizeUnknown {
+		c.onHit(seg, e)
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	if err := c.bs.PutCtx(ctx, bl); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.insertOrUpdate(&lfuEntry{key: k, present: true, size: len(bl.RawData())})
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *tinyLFUCache) PutMany(bs []blocks.Block) error {
+	return c.PutManyCtx(context.Background(), bs)
+}
+
+func (c *tinyLFUCache) PutManyCtx(ctx context.Context, bs []blocks.Block) error {
+	var toPut []blocks.Block
+	c.mu.Lock()
+	for _, bl := range bs {
+		if seg, e, ok := c.find(bl.Cid().KeyString()); ok && e.present && e.size != sizeUnknown {
+			c.onHit(seg, e)
+			continue
+		}
+		toPut = append(toPut, bl)
+	}
+	c.mu.Unlock()
+
+	if len(toPut) == 0 {
+		return nil
+	}
+
+	if err := c.bs.PutManyCtx(ctx, toPut); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for _, bl := range toPut {
+		c.insertOrUpdate(&lfuEntry{key: bl.Cid().KeyString(), present: true, size: len(bl.RawData())})
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *tinyLFUCache) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return c.bs.AllKeysChan(ctx)
+}
@@ -0,0 +1,179 @@
+package blockstore
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	delaystore "github.com/ipfs/go-datastore/delayed"
+	syncds "github.com/ipfs/go-datastore/sync"
+	delay "github.com/ipfs/go-ipfs-delay"
+)
+
+func createTinyLFUStores(t testing.TB) (*tinyLFUCache, Blockstore, *callbackDatastore) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	lfu, err := newTinyLFUCachedBS(bs, DefaultCacheOpts().HasARCCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lfu, bs, cd
+}
+
+func TestTinyLFUCreationFailure(t *testing.T) {
+	if lfu, err := newTinyLFUCachedBS(nil, -1); lfu != nil || err == nil {
+		t.Fatal("expected error and no cache")
+	}
+}
+
+func TestTinyLFUGetFillsCache(t *testing.T) {
+	lfu, _, cd := createTinyLFUStores(t)
+
+	if err := lfu.Put(exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lfu.Get(exampleBlock.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	trap("has hit datastore", cd, t)
+	if has, err := lfu.Has(exampleBlock.Cid()); !has || err != nil {
+		t.Fatal("has returned invalid result")
+	}
+	if size, err := lfu.GetSize(exampleBlock.Cid()); err != nil || size != len(exampleBlock.RawData()) {
+		t.Fatal("getsize returned invalid result", size, err)
+	}
+}
+
+func TestTinyLFURemoveCacheEntryOnDelete(t *testing.T) {
+	lfu, _, cd := createTinyLFUStores(t)
+
+	lfu.Put(exampleBlock)
+
+	writeHitTheDatastore := false
+	cd.SetFunc(func() { writeHitTheDatastore = true })
+
+	lfu.DeleteBlock(exampleBlock.Cid())
+	// DeleteBlock itself reaches the datastore; only the Put that follows
+	// should decide the outcome.
+	writeHitTheDatastore = false
+	lfu.Put(exampleBlock)
+	if !writeHitTheDatastore {
+		t.Fail()
+	}
+}
+
+func createTinyLFUStoresWithDelay(b testing.TB, delayed delay.D) (*tinyLFUCache, Blockstore, *callbackDatastore) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	slowStore := delaystore.New(cd, delayed)
+	bs := NewBlockstore(syncds.MutexWrap(slowStore))
+	lfu, err := newTinyLFUCachedBS(bs, DefaultCacheOpts().HasARCCacheSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return lfu, bs, cd
+}
+
+// thrashTable mirrors the workload shape used by the ARC Benchmark_Thrash*
+// benchmarks so the two policies can be compared head-to-head.
+var thrashTable = []struct {
+	numBlocks int
+	threads   int
+	delay     time.Duration
+}{
+	{numBlocks: 1_000_000, threads: 1, delay: time.Millisecond * 1},
+	{numBlocks: 1_000_000, threads: 32, delay: time.Millisecond * 1},
+	{numBlocks: 1_000_000, threads: 64, delay: time.Millisecond * 1},
+	{numBlocks: 1_000_000, threads: 500, delay: time.Millisecond * 1},
+}
+
+func Benchmark_ThrashPutTinyLFU(b *testing.B) {
+	for _, test := range thrashTable {
+		b.Run(fmt.Sprintf("%d_threads-%d_blocks", test.threads, test.numBlocks), func(b *testing.B) {
+			lfu, _, _ := createTinyLFUStoresWithDelay(b, delay.Fixed(test.delay))
+			trace := make([]blocks.Block, test.numBlocks)
+			for i := 0; i < test.numBlocks; i++ {
+				token := make([]byte, 4)
+				rand.Read(token)
+				trace[i] = blocks.NewBlock(token)
+			}
+
+			for i := 0; i < test.threads; i++ {
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.Put(trace[idx])
+					}
+				}()
+
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.Get(trace[idx].Cid())
+					}
+				}()
+
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.DeleteBlock(trace[idx].Cid())
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				lfu.Put(trace[i])
+			}
+		})
+	}
+}
+
+func Benchmark_ThrashGetTinyLFU(b *testing.B) {
+	for _, test := range thrashTable {
+		b.Run(fmt.Sprintf("%d_threads-%d_blocks", test.threads, test.numBlocks), func(b *testing.B) {
+			lfu, _, _ := createTinyLFUStoresWithDelay(b, delay.Fixed(test.delay))
+			trace := make([]blocks.Block, test.numBlocks)
+			for i := 0; i < test.numBlocks; i++ {
+				token := make([]byte, 4)
+				rand.Read(token)
+				trace[i] = blocks.NewBlock(token)
+			}
+
+			for i := 0; i < test.threads; i++ {
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.Put(trace[idx])
+					}
+				}()
+
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.Get(trace[idx].Cid())
+					}
+				}()
+
+				go func() {
+					for {
+						idx := rand.Intn(test.numBlocks - 1)
+						lfu.DeleteBlock(trace[idx].Cid())
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				lfu.Get(trace[i].Cid())
+			}
+		})
+	}
+}